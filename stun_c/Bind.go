@@ -0,0 +1,57 @@
+package stun_c
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+)
+
+// Endpoint identifies the two ends of a STUN exchange. Concrete Binds produce
+// their own Endpoint implementation (UDPEndpoint, StreamEndpoint, ...) since what
+// identifies a peer differs by transport.
+type Endpoint interface {
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+}
+
+// Bind abstracts the transport a STUN message travels over, so the same
+// request/response code in StunClient.go works whether the message rides over
+// UDP, TCP, or TLS (RFC 5389 §7.2.2). There is no DTLS implementation here - the
+// standard library doesn't have one, and pulling in a third-party DTLS library is
+// outside the scope of this package.
+type Bind interface {
+	// Send writes packet as a single STUN message to endpoint.
+	Send(packet []byte, endpoint Endpoint) error
+
+	// Receive blocks until a full STUN message is available, copies it into
+	// packet, and returns the number of bytes written and the endpoint it
+	// arrived from.
+	Receive(packet []byte) (int, Endpoint, error)
+
+	Close() error
+}
+
+// readFramedMessage reads exactly one STUN message off a stream transport
+// (TCP/TLS) into packet. Unlike UDP, a stream has no message boundaries, so the
+// 20-byte header's message-length field is what delimits one message from the
+// next (RFC 5389 §7.2.2) - the length is always a multiple of 4 since attributes
+// are padded to a 4-byte boundary.
+func readFramedMessage(r io.Reader, packet []byte) (int, error) {
+	header := packet[0:20]
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, err
+	}
+
+	message_length := binary.BigEndian.Uint16(header[2:4])
+	if int(message_length) > len(packet)-20 {
+		return 0, errors.New("stun_c: message length exceeds receive buffer")
+	}
+
+	body := packet[20 : 20+message_length]
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, err
+	}
+
+	return 20 + int(message_length), nil
+}
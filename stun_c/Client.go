@@ -0,0 +1,242 @@
+package stun_c
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"time"
+)
+
+// initialRTO is the starting retransmission timeout of Client.Do's schedule
+// (RFC 5389 §7.2.1). RFC 5389 lets an implementation pick something larger than
+// 500ms if it has a better RTT estimate; this package doesn't keep one, so it
+// just uses the RFC's suggested default.
+const initialRTO = 500 * time.Millisecond
+
+// Request describes an outgoing STUN request for Client.Do to send (with
+// retransmission, for unreliable transports) and match a response to.
+type Request struct {
+	// MessageType is the full STUN request message type, e.g. REQUEST for a
+	// Binding Request.
+	MessageType int16
+
+	// Body is the request's attribute bytes, not including MESSAGE-INTEGRITY or
+	// FINGERPRINT - Do appends those itself if Credentials/Fingerprint are set.
+	Body []byte
+
+	Credentials *Credentials
+	Fingerprint bool
+}
+
+type pendingRequest struct {
+	ch chan *Response
+	creds *Credentials
+}
+
+// Client multiplexes many concurrent requests over a single Bind. It owns the
+// socket: one goroutine reads every incoming message and dispatches it to
+// whichever Do call is waiting on that transaction ID, so callers no longer need
+// one Bind per outstanding request the way recvMessage's single-shot read did.
+type Client struct {
+	bind Bind
+	reliable bool
+
+	mu sync.Mutex
+	pending map[string]*pendingRequest
+
+	done chan struct{}
+}
+
+// NewClient wraps bind for concurrent use. reliable should be true for TCP/TLS
+// Binds and false for UDP - it decides whether Do retransmits (RFC 5389 §7.2.1)
+// or just waits out a single timeout (RFC 5389 §7.2.2). NewClient starts the
+// read loop immediately; call Close when done with the Client to stop it, or
+// Stop if bind is owned by someone else and must keep working afterwards.
+func NewClient(bind Bind, reliable bool) *Client {
+	c := &Client{bind: bind, reliable: reliable, pending: make(map[string]*pendingRequest), done: make(chan struct{})}
+	go c.readLoop()
+	return c
+}
+
+func (c *Client) readLoop() {
+	packet := make([]byte, 1280) // RFC 5389: Allocate enough for IPv6 packets too
+
+	for {
+		n, _, err := c.bind.Receive(packet)
+
+		select {
+		case <-c.done:
+			return // Stop was called; this packet (if any) is dropped, not dispatched
+		default:
+		}
+
+		if err != nil {
+			return // bind closed (or broken) out from under us - nothing left to read
+		}
+		if n < 20 {
+			continue // shorter than a STUN header, not a message we can parse
+		}
+
+		id := string(packet[8:20]) // transaction ID, per RFC 5389 §6
+
+		c.mu.Lock()
+		pending, ok := c.pending[id]
+		c.mu.Unlock()
+		if !ok {
+			continue // not a transaction of ours (late retransmission reply, stray packet, ...)
+		}
+
+		response, err := parseMessage(append([]byte{}, packet[:n]...), []byte(id), pending.creds)
+		if err != nil {
+			continue // malformed response, nothing sane to deliver to the waiter
+		}
+
+		select {
+		case pending.ch <- response:
+		default: // Do already gave up and stopped listening; drop it
+		}
+	}
+}
+
+func (c *Client) register(id string, creds *Credentials) chan *Response {
+	ch := make(chan *Response, 1)
+
+	c.mu.Lock()
+	c.pending[id] = &pendingRequest{ch: ch, creds: creds}
+	c.mu.Unlock()
+
+	return ch
+}
+
+func (c *Client) unregister(id string) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
+
+// Listen registers interest in a response matching transactionID without
+// sending anything, for callers that send their request over a different Bind
+// than the one they're listening on (RFC 5780's hairpinning test sends from a
+// probe socket but expects the reply on the caller's own socket). The returned
+// stop function must be called once the caller is done waiting, to release the
+// registration.
+func (c *Client) Listen(transactionID []byte) (<-chan *Response, func()) {
+	id := string(transactionID)
+	ch := c.register(id, nil)
+	return ch, func() { c.unregister(id) }
+}
+
+// ErrGiveUp is returned by Retransmit when its retransmission schedule
+// elapses with no response and no context cancellation.
+var ErrGiveUp = errors.New("stun_c: gave up retransmitting, no response")
+
+// Retransmit drives send/result according to RFC 5389 §7.2.1's schedule: over
+// an unreliable transport (reliable=false), 7 transmissions total, starting
+// immediately and doubling the interval each time (RTO, 2·RTO, 4·RTO, ...),
+// then one final 16·RTO wait before giving up with ErrGiveUp - 39.5s
+// altogether at the default 500ms RTO. Over a reliable transport it sends
+// once and just waits for ctx or result, per RFC 5389 §7.2.2. It's generic
+// over the result type so callers whose responses aren't a stun_c.Response -
+// turn_c's requests, which RFC 5766 §6.1 requires to use this same schedule -
+// can reuse it without reimplementing the timing.
+func Retransmit[T any](ctx context.Context, reliable bool, send func() error, result <-chan T) (T, error) {
+	var zero T
+
+	if reliable {
+		if err := send(); err != nil {
+			return zero, err
+		}
+
+		select {
+		case response := <-result:
+			return response, nil
+		case <-ctx.Done():
+			return zero, ctx.Err()
+		}
+	}
+
+	// RFC 5389 §7.2.1: waits[i] is how long to wait before the (i+1)th
+	// transmission; after the 7th, one final 16*RTO wait before giving up.
+	waits := []time.Duration{0, initialRTO, 2 * initialRTO, 4 * initialRTO, 8 * initialRTO, 16 * initialRTO, 32 * initialRTO}
+
+	timer := time.NewTimer(waits[0])
+	defer timer.Stop()
+
+	for i := range waits {
+		select {
+		case <-timer.C:
+			if err := send(); err != nil {
+				return zero, err
+			}
+
+			if i+1 < len(waits) {
+				timer.Reset(waits[i+1])
+			} else {
+				timer.Reset(16 * initialRTO) // the final wait after the 7th and last transmission
+			}
+
+		case response := <-result:
+			return response, nil
+
+		case <-ctx.Done():
+			return zero, ctx.Err()
+		}
+	}
+
+	select {
+	case <-timer.C:
+		return zero, ErrGiveUp
+	case response := <-result:
+		return response, nil
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
+
+// Do sends req to server and waits for its matching response, retransmitting
+// it per Retransmit if c is unreliable. It returns ErrGiveUp if that schedule
+// runs out with no response.
+func (c *Client) Do(ctx context.Context, server Endpoint, req *Request) (*Response, error) {
+	secureRandomNumber := make([]byte, trans_id_size/8)
+	if _, err := rand.Read(secureRandomNumber); err != nil {
+		return nil, &Gen_Random_Error{}
+	}
+
+	header, err := makeHeader(req.MessageType, 0, MAGIC_COOKIE, secureRandomNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	body := req.Body
+	if req.Credentials != nil {
+		body = appendMessageIntegrity(header, body, req.Credentials)
+	}
+	if req.Fingerprint {
+		body = appendFingerprint(header, body)
+	}
+	header = withLength(header, len(body))
+
+	message := append(append([]byte{}, header...), body...)
+
+	id := string(secureRandomNumber)
+	ch := c.register(id, req.Credentials)
+	defer c.unregister(id)
+
+	return Retransmit(ctx, c.reliable, func() error { return c.bind.Send(message, server) }, ch)
+}
+
+// Stop halts the read loop without touching bind, for callers that don't own
+// it and must leave it usable once they're done with the Client (e.g.
+// DiscoverNATBehavior, which only borrows the caller's bind). It can't
+// interrupt an in-flight Receive - Bind has no way to do that - so the read
+// loop exits as soon as that call returns rather than dispatching its result.
+// Must not be called more than once.
+func (c *Client) Stop() {
+	close(c.done)
+}
+
+// Close shuts down the underlying Bind, which in turn stops the read loop.
+func (c *Client) Close() error {
+	return c.bind.Close()
+}
@@ -0,0 +1,147 @@
+package stun_c
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/binary"
+	"hash/crc32"
+	"strings"
+)
+
+// Credentials carries either long-term (Username+Realm+Password) or short-term
+// (Password only) material used to key MESSAGE-INTEGRITY, per RFC 5389 15.4.
+type Credentials struct {
+	Username string
+	Realm string
+	Password string
+}
+
+// key derives the HMAC-SHA1 key: MD5(username ":" realm ":" password) for long-term
+// credentials (Username or Realm set), or a SASLprep'd password for short-term ones.
+func (c *Credentials) key() []byte {
+	if c.Username != "" || c.Realm != "" {
+		sum := md5.Sum([]byte(c.Username + ":" + c.Realm + ":" + c.Password))
+		return sum[:]
+	}
+
+	return []byte(saslprep(c.Password))
+}
+
+// saslprep is not a full RFC 4013 implementation - too lazy - it just strips the
+// handful of characters (soft hyphen, zero-width spaces) that SASLprep maps out.
+func saslprep(password string) string {
+	return strings.Map(func(r rune) rune {
+		if r == 0x00AD || (r >= 0x200B && r <= 0x200F) {
+			return -1
+		}
+		return r
+	}, password)
+}
+
+// makeAttribute builds a single TLV STUN attribute, zero-padded to a 4-byte
+// boundary as required by RFC 5389 §15.
+func makeAttribute(attr_type uint16, value []byte) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, attr_type)
+	binary.Write(buf, binary.BigEndian, uint16(len(value)))
+	buf.Write(value)
+
+	if pad := (4 - len(value)%4) % 4; pad != 0 {
+		buf.Write(make([]byte, pad))
+	}
+
+	return buf.Bytes()
+}
+
+// withLength returns a copy of header with the message-length field patched to
+// body_len, so MESSAGE-INTEGRITY/FINGERPRINT can be hashed as if the attribute
+// being built were already accounted for (RFC 5389 §15.4, §15.5).
+func withLength(header []byte, body_len int) []byte {
+	patched := make([]byte, len(header))
+	copy(patched, header)
+	binary.BigEndian.PutUint16(patched[2:4], uint16(body_len))
+	return patched
+}
+
+// appendMessageIntegrity appends a MESSAGE-INTEGRITY attribute (HMAC-SHA1 over the
+// header and the body built so far) keyed off creds.
+func appendMessageIntegrity(header, body []byte, creds *Credentials) []byte {
+	integrity_header := withLength(header, len(body)+24) // +4 type/len, +20 HMAC-SHA1
+
+	mac := hmac.New(sha1.New, creds.key())
+	mac.Write(integrity_header)
+	mac.Write(body)
+
+	result := make([]byte, len(body))
+	copy(result, body)
+	return append(result, makeAttribute(MESSAGE_INTEGRITY, mac.Sum(nil))...)
+}
+
+// appendFingerprint appends a FINGERPRINT attribute: CRC-32 of the message built
+// so far, XORed with 0x5354554E (RFC 5389 §15.5).
+func appendFingerprint(header, body []byte) []byte {
+	fingerprint_header := withLength(header, len(body)+8) // +4 type/len, +4 CRC-32
+
+	crc := crc32.ChecksumIEEE(append(append([]byte{}, fingerprint_header...), body...)) ^ uint32(FINGERPRINT_XOR)
+
+	value := make([]byte, 4)
+	binary.BigEndian.PutUint32(value, crc)
+
+	result := make([]byte, len(body))
+	copy(result, body)
+	return append(result, makeAttribute(FINGERPRINT, value)...)
+}
+
+// verifyMessageIntegrity recomputes the HMAC-SHA1 over header+body[0:offset] (the
+// bytes that preceded the MESSAGE-INTEGRITY attribute) and compares it to the
+// attribute's value.
+func verifyMessageIntegrity(header, body []byte, offset int, creds *Credentials, received []byte) bool {
+	integrity_header := withLength(header, offset+24)
+
+	mac := hmac.New(sha1.New, creds.key())
+	mac.Write(integrity_header)
+	mac.Write(body[0:offset])
+
+	return hmac.Equal(mac.Sum(nil), received)
+}
+
+// verifyFingerprint recomputes the CRC-32 over header+body[0:offset] (the bytes
+// that preceded the FINGERPRINT attribute) and compares it to the attribute's value.
+func verifyFingerprint(header, body []byte, offset int, received []byte) bool {
+	fingerprint_header := withLength(header, offset+8)
+
+	crc := crc32.ChecksumIEEE(append(append([]byte{}, fingerprint_header...), body[0:offset]...)) ^ uint32(FINGERPRINT_XOR)
+
+	value := make([]byte, 4)
+	binary.BigEndian.PutUint32(value, crc)
+
+	return bytes.Equal(value, received)
+}
+
+// get_error_code decodes an ERROR-CODE attribute (RFC 5389 §15.6) into the
+// combined class*100+number error code and the UTF-8 reason phrase.
+func get_error_code(attribute []byte) (int, string) {
+	if len(attribute) < 4 {
+		return 0, ""
+	}
+
+	class := int(attribute[2] & 0x07)
+	number := int(attribute[3])
+
+	return class*100 + number, string(attribute[4:])
+}
+
+// get_unknown_attributes decodes an UNKNOWN-ATTRIBUTES attribute (RFC 5389 §15.9)
+// into the list of attribute types the peer didn't understand.
+func get_unknown_attributes(attribute []byte) []uint16 {
+	count := len(attribute) / 2
+	types := make([]uint16, 0, count)
+
+	for i := 0; i < count; i++ {
+		types = append(types, binary.BigEndian.Uint16(attribute[i*2:i*2+2]))
+	}
+
+	return types
+}
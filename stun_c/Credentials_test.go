@@ -0,0 +1,175 @@
+package stun_c
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+)
+
+// RFC 5769 §2.1's request uses this USERNAME/password pair for its
+// MESSAGE-INTEGRITY example.
+const testUsername = "evtj:h6vY"
+const testPassword = "VOkJxbRl1RmTxUk/WvJxBt"
+
+func testHeader() []byte {
+	header := make([]byte, 20)
+	binary.BigEndian.PutUint16(header[0:2], uint16(REQUEST))
+	binary.BigEndian.PutUint32(header[4:8], uint32(MAGIC_COOKIE))
+	copy(header[8:20], []byte("b7e7a701bc34"))
+	return header
+}
+
+func TestMakeAttributePadding(t *testing.T) {
+	got := makeAttribute(USERNAME, []byte(testUsername)) // 9 bytes, needs 3 bytes of padding
+
+	want := []byte{0x00, 0x06, 0x00, 0x09}
+	want = append(want, []byte(testUsername)...)
+	want = append(want, 0x00, 0x00, 0x00)
+
+	if !bytes.Equal(got, want) {
+		t.Fatalf("makeAttribute() = %x, want %x", got, want)
+	}
+}
+
+func TestWithLength(t *testing.T) {
+	header := testHeader()
+	patched := withLength(header, 0x58)
+
+	if got := binary.BigEndian.Uint16(patched[2:4]); got != 0x58 {
+		t.Fatalf("withLength() patched length = %#x, want 0x58", got)
+	}
+	if !bytes.Equal(header[2:4], []byte{0x00, 0x00}) {
+		t.Fatal("withLength() mutated the original header in place")
+	}
+}
+
+// TestAppendMessageIntegrityShortTerm recomputes the HMAC-SHA1 independently
+// of appendMessageIntegrity, to catch a regression in attribute ordering or
+// length-patching rather than just re-running the same code twice.
+func TestAppendMessageIntegrityShortTerm(t *testing.T) {
+	header := testHeader()
+	body := makeAttribute(USERNAME, []byte(testUsername))
+	creds := &Credentials{Password: testPassword}
+
+	got := appendMessageIntegrity(header, body, creds)
+
+	integrityHeader := withLength(header, len(body)+24)
+	mac := hmac.New(sha1.New, []byte(saslprep(testPassword)))
+	mac.Write(integrityHeader)
+	mac.Write(body)
+
+	want := append(append([]byte{}, body...), makeAttribute(MESSAGE_INTEGRITY, mac.Sum(nil))...)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("appendMessageIntegrity() = %x, want %x", got, want)
+	}
+}
+
+func TestAppendMessageIntegrityLongTerm(t *testing.T) {
+	header := testHeader()
+	body := makeAttribute(USERNAME, []byte(testUsername))
+	creds := &Credentials{Username: testUsername, Realm: "example.org", Password: testPassword}
+
+	got := appendMessageIntegrity(header, body, creds)
+
+	key := md5.Sum([]byte(testUsername + ":example.org:" + testPassword))
+	integrityHeader := withLength(header, len(body)+24)
+	mac := hmac.New(sha1.New, key[:])
+	mac.Write(integrityHeader)
+	mac.Write(body)
+
+	want := append(append([]byte{}, body...), makeAttribute(MESSAGE_INTEGRITY, mac.Sum(nil))...)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("appendMessageIntegrity() = %x, want %x", got, want)
+	}
+}
+
+func TestVerifyMessageIntegrityRoundTrip(t *testing.T) {
+	header := testHeader()
+	body := makeAttribute(USERNAME, []byte(testUsername))
+	creds := &Credentials{Password: testPassword}
+
+	full := appendMessageIntegrity(header, body, creds)
+	offset := len(body)
+	value := full[offset+4:] // skip the MESSAGE-INTEGRITY attribute's type+length
+
+	if !verifyMessageIntegrity(header, full, offset, creds, value) {
+		t.Fatal("verifyMessageIntegrity() rejected a message it just signed")
+	}
+
+	if verifyMessageIntegrity(header, full, offset, &Credentials{Password: "wrong"}, value) {
+		t.Fatal("verifyMessageIntegrity() accepted the wrong password")
+	}
+}
+
+func TestAppendFingerprint(t *testing.T) {
+	header := testHeader()
+	body := makeAttribute(USERNAME, []byte(testUsername))
+
+	got := appendFingerprint(header, body)
+
+	fingerprintHeader := withLength(header, len(body)+8)
+	crc := crc32.ChecksumIEEE(append(append([]byte{}, fingerprintHeader...), body...)) ^ uint32(FINGERPRINT_XOR)
+	value := make([]byte, 4)
+	binary.BigEndian.PutUint32(value, crc)
+
+	want := append(append([]byte{}, body...), makeAttribute(FINGERPRINT, value)...)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("appendFingerprint() = %x, want %x", got, want)
+	}
+}
+
+func TestVerifyFingerprintRoundTrip(t *testing.T) {
+	header := testHeader()
+	body := makeAttribute(USERNAME, []byte(testUsername))
+
+	full := appendFingerprint(header, body)
+	offset := len(body)
+	value := full[offset+4:]
+
+	if !verifyFingerprint(header, full, offset, value) {
+		t.Fatal("verifyFingerprint() rejected a message it just stamped")
+	}
+
+	tampered := append([]byte{}, full...)
+	tampered[0] ^= 0xFF
+	if verifyFingerprint(header, tampered, offset, value) {
+		t.Fatal("verifyFingerprint() accepted a tampered message")
+	}
+}
+
+func TestGetErrorCode(t *testing.T) {
+	attr := append([]byte{0x00, 0x00, 0x04, 0x01}, []byte("Unauthorized")...)
+
+	code, reason := get_error_code(attr)
+	if code != 401 || reason != "Unauthorized" {
+		t.Fatalf("get_error_code() = (%d, %q), want (401, \"Unauthorized\")", code, reason)
+	}
+}
+
+func TestGetUnknownAttributes(t *testing.T) {
+	attr := make([]byte, 4)
+	binary.BigEndian.PutUint16(attr[0:2], 0x0002)
+	binary.BigEndian.PutUint16(attr[2:4], 0x0003)
+
+	got := get_unknown_attributes(attr)
+	want := []uint16{0x0002, 0x0003}
+
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("get_unknown_attributes() = %v, want %v", got, want)
+	}
+}
+
+func TestSaslprepStripsMappedCharacters(t *testing.T) {
+	// ­ is a soft hyphen, ​ a zero-width space - both fall in the
+	// ranges saslprep strips.
+	input := "pa" + string(rune(0x00AD)) + "ss" + string(rune(0x200B)) + "word"
+
+	got := saslprep(input)
+	if got != "password" {
+		t.Fatalf("saslprep() = %q, want %q", got, "password")
+	}
+}
@@ -0,0 +1,287 @@
+package stun_c
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+// RFC 5780 §7.2 CHANGE-REQUEST flags
+const change_ip_flag uint32 = 0x00000004
+const change_port_flag uint32 = 0x00000002
+
+// discoveryTimeout bounds how long a single filtering-behavior probe waits for a
+// response before concluding the request was filtered by the NAT
+const discoveryTimeout = 3 * time.Second
+
+// NATMappingBehavior classifies how a NAT maps an internal 5-tuple to an
+// external one (RFC 5780 §4.3).
+type NATMappingBehavior int
+
+const (
+	MappingUnknown NATMappingBehavior = iota
+	EndpointIndependentMapping
+	AddressDependentMapping
+	AddressAndPortDependentMapping
+)
+
+// NATFilteringBehavior classifies which inbound packets a NAT lets through to
+// an already-mapped port (RFC 5780 §4.4).
+type NATFilteringBehavior int
+
+const (
+	FilteringUnknown NATFilteringBehavior = iota
+	EndpointIndependentFiltering
+	AddressDependentFiltering
+	AddressAndPortDependentFiltering
+)
+
+// NATInfo is the result of DiscoverNATBehavior.
+type NATInfo struct {
+	Mapping NATMappingBehavior
+	Filtering NATFilteringBehavior
+	Hairpinning bool
+	BindingLifetime time.Duration
+}
+
+func changeRequestAttribute(change_ip, change_port bool) []byte {
+	var flags uint32
+	if change_ip {
+		flags |= change_ip_flag
+	}
+	if change_port {
+		flags |= change_port_flag
+	}
+
+	value := make([]byte, 4)
+	binary.BigEndian.PutUint32(value, flags)
+
+	return makeAttribute(CHANGE_REQUEST, value)
+}
+
+func addrEqual(a, b *net.UDPAddr) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.IP.Equal(b.IP) && a.Port == b.Port
+}
+
+// sendBindingRequest issues a single Binding Request, optionally carrying a
+// CHANGE-REQUEST attribute, via client - which dispatches the response back to
+// this call by transaction ID, rather than everyone in this package racing a
+// bare bind.Receive on a shared socket.
+func sendBindingRequest(ctx context.Context, client *Client, endpoint Endpoint, change_ip, change_port bool) (*Response, error) {
+	body := []byte{}
+	if change_ip || change_port {
+		body = changeRequestAttribute(change_ip, change_port)
+	}
+
+	return client.Do(ctx, endpoint, &Request{MessageType: REQUEST, Body: body})
+}
+
+// sendBindingRequestTimeout is sendBindingRequest bounded to timeout, used
+// where a lack of response is itself the interesting result (the filtering
+// tests) - Client.Do's own ctx.Done() handling stops retransmitting and
+// releases the transaction registration as soon as the deadline passes.
+func sendBindingRequestTimeout(client *Client, endpoint Endpoint, change_ip, change_port bool, timeout time.Duration) (*Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return sendBindingRequest(ctx, client, endpoint, change_ip, change_port)
+}
+
+// discoverMapping runs RFC 5780 §4.3's three-test recipe to classify mapping
+// behavior, reusing the Test I response the caller already made.
+func discoverMapping(client *Client, server *net.UDPAddr, first *Response) (NATMappingBehavior, error) {
+	if first.OtherAddress == nil {
+		return MappingUnknown, errors.New("STUN Client: server did not return OTHER-ADDRESS, cannot determine mapping behavior")
+	}
+
+	// Test II: same request, sent to the server's secondary IP but its primary port
+	second_server := &net.UDPAddr{IP: first.OtherAddress.IP, Port: server.Port}
+	second, err := sendBindingRequest(context.Background(), client, NewUDPEndpoint(nil, second_server), false, false)
+	if err != nil {
+		return MappingUnknown, err
+	}
+	if second.Address == nil {
+		return MappingUnknown, errors.New("STUN Client: server did not return a mapped address")
+	}
+	if addrEqual(first.Address, second.Address) {
+		return EndpointIndependentMapping, nil
+	}
+
+	// Test III: the server's secondary IP and secondary port
+	third, err := sendBindingRequest(context.Background(), client, NewUDPEndpoint(nil, first.OtherAddress), false, false)
+	if err != nil {
+		return MappingUnknown, err
+	}
+	if third.Address == nil {
+		return MappingUnknown, errors.New("STUN Client: server did not return a mapped address")
+	}
+	if addrEqual(second.Address, third.Address) {
+		return AddressDependentMapping, nil
+	}
+
+	return AddressAndPortDependentMapping, nil
+}
+
+// discoverFiltering runs RFC 5780 §4.4's CHANGE-REQUEST recipe to classify
+// filtering behavior.
+func discoverFiltering(client *Client, server *net.UDPAddr) NATFilteringBehavior {
+	// Test II: ask the server to respond from a different IP and port
+	if _, err := sendBindingRequestTimeout(client, NewUDPEndpoint(nil, server), true, true, discoveryTimeout); err == nil {
+		return EndpointIndependentFiltering
+	}
+
+	// Test III: ask it to respond from the same IP, but a different port
+	if _, err := sendBindingRequestTimeout(client, NewUDPEndpoint(nil, server), false, true, discoveryTimeout); err == nil {
+		return AddressDependentFiltering
+	}
+
+	return AddressAndPortDependentFiltering
+}
+
+// discoverHairpinning checks whether packets sent to our own reflexive address,
+// from a second socket behind the same NAT, get routed back to us by the NAT
+// itself rather than out onto the public internet. The reply has to arrive on
+// client's own socket (that's the point of the test), so it registers for the
+// transaction with client.Listen rather than sending the request through
+// client.Do - the probe socket is only ever used to send.
+func discoverHairpinning(client *Client, mapped *net.UDPAddr) bool {
+	probe_conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return false
+	}
+	defer probe_conn.Close()
+	probe_bind := NewUDPBind(probe_conn)
+
+	secureRandomNumber := make([]byte, trans_id_size/8)
+	if _, err := rand.Read(secureRandomNumber); err != nil {
+		return false
+	}
+
+	header, err := makeHeader(REQUEST, 0, MAGIC_COOKIE, secureRandomNumber)
+	if err != nil {
+		return false
+	}
+
+	ch, stop := client.Listen(secureRandomNumber)
+	defer stop()
+
+	if err := sendMessage(probe_bind, NewUDPEndpoint(nil, mapped), header, nil); err != nil {
+		return false
+	}
+
+	select {
+	case response := <-ch:
+		return response != nil
+	case <-time.After(discoveryTimeout):
+		return false
+	}
+}
+
+// estimateBindingLifetime binary-searches the delay between two Binding
+// Requests that reuse the same 5-tuple, to find roughly how long the caller's
+// NAT keeps a mapping alive before it expires. Each trial opens its own socket,
+// since a mapping that's expired can't be un-expired for the next trial.
+func estimateBindingLifetime(server *net.UDPAddr) time.Duration {
+	try := func(delay time.Duration) (bool, error) {
+		conn, err := net.ListenUDP("udp", nil)
+		if err != nil {
+			return false, err
+		}
+		defer conn.Close()
+		client := NewClient(NewUDPBind(conn), false)
+
+		first, err := sendBindingRequest(context.Background(), client, NewUDPEndpoint(nil, server), false, false)
+		if err != nil || first.Address == nil {
+			return false, err
+		}
+
+		time.Sleep(delay)
+
+		second, err := sendBindingRequest(context.Background(), client, NewUDPEndpoint(nil, server), false, false)
+		if err != nil || second.Address == nil {
+			return false, nil // request was dropped, or the mapping changed underneath us
+		}
+
+		return addrEqual(first.Address, second.Address), nil
+	}
+
+	// Double the delay until a trial outlives the binding, to find an upper bound
+	low := time.Duration(0)
+	high := 30 * time.Second
+	for {
+		alive, err := try(high)
+		if err != nil {
+			return 0
+		}
+		if !alive {
+			break
+		}
+
+		low = high
+		high *= 2
+		if high > 10*time.Minute {
+			return high // binding outlives anything we're willing to wait for
+		}
+	}
+
+	// Binary search [low, high) for the boundary
+	for high-low > 5*time.Second {
+		mid := low + (high-low)/2
+
+		alive, err := try(mid)
+		if err != nil {
+			break
+		}
+
+		if alive {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+
+	return low
+}
+
+// DiscoverNATBehavior runs the RFC 5780 tests against server and classifies the
+// caller's NAT: mapping behavior, filtering behavior, whether hairpinning works,
+// and an estimate of how long a binding stays alive. bind's socket is reused for
+// every test except the hairpinning and binding-lifetime probes, which need
+// independent sockets to simulate a second host or a fresh 5-tuple. bind is
+// wrapped in a Client so every one of these tests - including the ones that
+// expect no response at all - dispatches by transaction ID instead of racing
+// each other for whatever bind.Receive returns next. The Client is stopped
+// (not closed) before returning, since bind belongs to the caller and must
+// still be usable afterwards.
+func DiscoverNATBehavior(bind Bind, server *net.UDPAddr) (*NATInfo, error) {
+	client := NewClient(bind, false)
+	defer client.Stop() // bind is the caller's, not ours to Close
+
+	first, err := sendBindingRequest(context.Background(), client, NewUDPEndpoint(nil, server), false, false)
+	if err != nil {
+		return nil, err
+	}
+	if first.Address == nil {
+		return nil, errors.New("STUN Client: server did not return a mapped address")
+	}
+
+	info := &NATInfo{}
+
+	info.Mapping, err = discoverMapping(client, server, first)
+	if err != nil {
+		return nil, err
+	}
+
+	info.Filtering = discoverFiltering(client, server)
+	info.Hairpinning = discoverHairpinning(client, first.Address)
+	info.BindingLifetime = estimateBindingLifetime(server)
+
+	return info, nil
+}
@@ -0,0 +1,459 @@
+package stun_c
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ServerOptions controls a Server's optional behavior. A zero-value
+// ServerOptions answers every Binding Request directly, with no SOFTWARE,
+// FINGERPRINT, authentication, or rate limiting.
+type ServerOptions struct {
+	Software string
+	Fingerprint bool
+
+	// Realm enables long-term credential authentication (RFC 5389 §10.2) when
+	// non-empty: a request with no MESSAGE-INTEGRITY gets a 401 challenge with
+	// REALM+NONCE, and Authenticate is consulted to verify a retry's integrity.
+	Realm string
+	Authenticate func(username string) (password string, ok bool)
+
+	// AlternateServer, if set, redirects every request to it with a 300 Try
+	// Alternate response (RFC 5389 §11) instead of answering directly - useful
+	// for shedding load onto a less-busy server.
+	AlternateServer *net.UDPAddr
+
+	// RateLimit and RateBurst configure a token-bucket limiter keyed by source
+	// IP, so this Server can't be used as an open reflection amplifier.
+	// RateLimit <= 0 disables rate limiting.
+	RateLimit int
+	RateBurst int
+}
+
+type tokenBucket struct {
+	tokens float64
+	last time.Time
+}
+
+type nonceEntry struct {
+	value string
+	issued time.Time
+}
+
+// entryTTL is how long a rate-limit bucket or outstanding NONCE is kept after
+// its last use. Without this, buckets/nonces (both keyed by source IP) grow
+// without bound under exactly the traffic this feature exists to survive -
+// reflection amplification, where an attacker sprays requests from many
+// (often spoofed) source IPs.
+const entryTTL = 5 * time.Minute
+
+// sweepInterval bounds how often sweepLocked actually walks the maps, so a
+// busy Server isn't paying an O(n) scan on every single request.
+const sweepInterval = 1 * time.Minute
+
+// Server answers STUN Binding Requests over any Bind - UDP, TCP, or TLS.
+type Server struct {
+	bind Bind
+	opts ServerOptions
+
+	mu sync.Mutex
+	buckets map[string]*tokenBucket
+	nonces map[string]nonceEntry // source IP -> last NONCE challenged with it
+	lastSweep time.Time
+}
+
+// Invalid_Server_Options is returned by NewServer when opts sets up a
+// combination it refuses to serve with - see NewServer.
+type Invalid_Server_Options struct {
+	reason string
+}
+
+func (e *Invalid_Server_Options) Error() string {
+	return fmt.Sprintf("STUN Server: invalid ServerOptions: %s", e.reason)
+}
+
+// NewServer wraps bind to answer Binding Requests according to opts (nil for
+// defaults). Call Serve to start answering. Returns an error if opts sets
+// Realm without Authenticate, since every authenticated request would
+// otherwise call a nil function and panic in handle's goroutine.
+func NewServer(bind Bind, opts *ServerOptions) (*Server, error) {
+	s := &Server{bind: bind, buckets: make(map[string]*tokenBucket), nonces: make(map[string]nonceEntry)}
+	if opts != nil {
+		s.opts = *opts
+	}
+
+	if s.opts.Realm != "" && s.opts.Authenticate == nil {
+		return nil, &Invalid_Server_Options{"Realm is set but Authenticate is nil"}
+	}
+
+	return s, nil
+}
+
+// Serve reads requests off bind until it returns an error (e.g. because Close
+// was called), answering each on its own goroutine so one slow or malicious
+// client can't stall the rest. Rate-limited packets are dropped here, before
+// spawning a goroutine for them, so a reflection-amplification flood doesn't
+// still cost a goroutine spawn per packet.
+func (s *Server) Serve() error {
+	for {
+		packet := make([]byte, 1280) // RFC 5389: Allocate enough for IPv6 packets too
+
+		n, client, err := s.bind.Receive(packet)
+		if err != nil {
+			return err
+		}
+
+		if !s.allow(clientIP(client)) {
+			continue // rate-limited: drop before even paying for a goroutine
+		}
+
+		go s.handle(packet[:n], client)
+	}
+}
+
+// Close shuts down the underlying Bind, which in turn stops Serve.
+func (s *Server) Close() error {
+	return s.bind.Close()
+}
+
+func clientIP(client Endpoint) string {
+	host, _, err := net.SplitHostPort(client.RemoteAddr().String())
+	if err != nil {
+		return client.RemoteAddr().String()
+	}
+	return host
+}
+
+// clientUDPAddr turns client's address into a *net.UDPAddr regardless of which
+// transport it actually arrived over, since XOR-MAPPED-ADDRESS is built the
+// same way no matter the transport (RFC 5389 §7.2.2).
+func clientUDPAddr(client Endpoint) (*net.UDPAddr, bool) {
+	host, port_str, err := net.SplitHostPort(client.RemoteAddr().String())
+	if err != nil {
+		return nil, false
+	}
+
+	port, err := strconv.Atoi(port_str)
+	if err != nil {
+		return nil, false
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, false
+	}
+
+	return &net.UDPAddr{IP: ip, Port: port}, true
+}
+
+// sweepLocked evicts buckets and nonces that haven't been touched in
+// entryTTL, at most once per sweepInterval. Callers must already hold s.mu.
+func (s *Server) sweepLocked(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for ip, bucket := range s.buckets {
+		if now.Sub(bucket.last) > entryTTL {
+			delete(s.buckets, ip)
+		}
+	}
+	for ip, entry := range s.nonces {
+		if now.Sub(entry.issued) > entryTTL {
+			delete(s.nonces, ip)
+		}
+	}
+}
+
+// allow reports whether ip still has a token in its bucket, refilling it for
+// the time elapsed since it was last checked (a standard token bucket).
+func (s *Server) allow(ip string) bool {
+	if s.opts.RateLimit <= 0 {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweepLocked(time.Now())
+
+	bucket, ok := s.buckets[ip]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(s.opts.RateBurst), last: time.Now()}
+		s.buckets[ip] = bucket
+	}
+
+	now := time.Now()
+	bucket.tokens += now.Sub(bucket.last).Seconds() * float64(s.opts.RateLimit)
+	if bucket.tokens > float64(s.opts.RateBurst) {
+		bucket.tokens = float64(s.opts.RateBurst)
+	}
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// parseRequest validates packet as a Binding Request and splits it into its
+// header, body, and transaction ID. Anything else (an indication, a response
+// somehow looped back to us, a malformed packet) is silently dropped - a STUN
+// server has no one to usefully report parse errors to.
+func parseRequest(packet []byte) (header, body, transaction_id []byte, ok bool) {
+	if len(packet) < 20 {
+		return nil, nil, nil, false
+	}
+
+	header = packet[0:20]
+
+	var data struct {
+		Message_type, Message_length int16
+		Magic_cookie int32
+		Transaction_id [12]byte
+	}
+	if err := binary.Read(bytes.NewBuffer(header), binary.BigEndian, &data); err != nil {
+		return nil, nil, nil, false
+	}
+
+	if data.Message_type != REQUEST || int(data.Message_length) > len(packet)-20 {
+		return nil, nil, nil, false
+	}
+
+	body = packet[20 : 20+int(data.Message_length)]
+	transaction_id = append([]byte{}, data.Transaction_id[0:12]...)
+
+	return header, body, transaction_id, true
+}
+
+// scanAttributes walks body once, pulling out the few attributes handle needs
+// and collecting any comprehension-required attribute type it doesn't
+// recognize (RFC 5389 §15.9).
+func scanAttributes(body []byte) (username, nonce string, messageIntegrityOffset int, messageIntegrityValue []byte, unknown []uint16) {
+	messageIntegrityOffset = -1
+
+	remaining := body
+	bytes_read := 0
+	for bytes_read < len(body) {
+		offset := bytes_read
+
+		attr_type, _, attr_value, r, err := getSingleAttribute(remaining)
+		if err != nil {
+			break
+		}
+
+		bytes_read += r
+		remaining = remaining[r:]
+
+		switch attr_type {
+		case USERNAME:
+			username = string(attr_value)
+
+		case NONCE:
+			nonce = string(attr_value)
+
+		case MESSAGE_INTEGRITY:
+			messageIntegrityOffset = offset
+			messageIntegrityValue = attr_value
+
+		case REALM:
+			// just echoed back by a retrying client, nothing to do with it here
+
+		case FINGERPRINT:
+			// comprehension-optional and purely a client-side sanity check - the
+			// server has no reason to verify it
+
+		default:
+			if attr_type < 0x8000 {
+				unknown = append(unknown, attr_type)
+			}
+		}
+	}
+
+	return
+}
+
+func (s *Server) handle(packet []byte, client Endpoint) {
+	header, body, transaction_id, ok := parseRequest(packet)
+	if !ok {
+		return
+	}
+
+	if s.opts.AlternateServer != nil {
+		s.sendError(client, transaction_id, 300, "Try Alternate", makeAddrAttribute(ALTERNATE_SERVER, s.opts.AlternateServer))
+		return
+	}
+
+	username, nonce, messageIntegrityOffset, messageIntegrityValue, unknown := scanAttributes(body)
+
+	if len(unknown) > 0 {
+		s.sendError(client, transaction_id, 420, "Unknown Attribute", makeAttribute(UNKNOWN_ATTRIBUTES, unknownAttributesValue(unknown)))
+		return
+	}
+
+	var creds *Credentials
+	if s.opts.Realm != "" {
+		creds, ok = s.authenticate(client, transaction_id, header, body, username, nonce, messageIntegrityOffset, messageIntegrityValue)
+		if !ok {
+			return // authenticate already sent the 401/438 challenge
+		}
+	}
+
+	s.respondSuccess(client, transaction_id, creds)
+}
+
+// authenticate runs the RFC 5389 §10.2 long-term credential check, issuing a
+// 401 or 438 challenge itself (with a fresh NONCE) whenever it fails.
+func (s *Server) authenticate(client Endpoint, transaction_id, header, body []byte, username, nonce string, messageIntegrityOffset int, messageIntegrityValue []byte) (*Credentials, bool) {
+	ip := clientIP(client)
+
+	if messageIntegrityOffset < 0 {
+		s.challenge(client, transaction_id, ip, 401, "Unauthorized")
+		return nil, false
+	}
+
+	s.mu.Lock()
+	expected := s.nonces[ip]
+	s.mu.Unlock()
+
+	if nonce == "" || nonce != expected.value {
+		s.challenge(client, transaction_id, ip, 438, "Stale Nonce")
+		return nil, false
+	}
+
+	password, ok := s.opts.Authenticate(username)
+	if !ok {
+		s.challenge(client, transaction_id, ip, 401, "Unauthorized")
+		return nil, false
+	}
+
+	creds := &Credentials{Username: username, Realm: s.opts.Realm, Password: password}
+	if !verifyMessageIntegrity(header, body, messageIntegrityOffset, creds, messageIntegrityValue) {
+		s.challenge(client, transaction_id, ip, 401, "Unauthorized")
+		return nil, false
+	}
+
+	return creds, true
+}
+
+// challenge issues a fresh NONCE for ip and sends it back in a 401/438 error
+// response alongside REALM.
+func (s *Server) challenge(client Endpoint, transaction_id []byte, ip string, code int, reason string) {
+	nonce := newNonce()
+
+	s.mu.Lock()
+	s.sweepLocked(time.Now())
+	s.nonces[ip] = nonceEntry{value: nonce, issued: time.Now()}
+	s.mu.Unlock()
+
+	body := makeAttribute(REALM, []byte(s.opts.Realm))
+	body = append(body, makeAttribute(NONCE, []byte(nonce))...)
+
+	s.sendError(client, transaction_id, code, reason, body)
+}
+
+// sendError sends an ERROR_RESPONSE carrying ERROR-CODE followed by extra
+// (whatever attributes the particular error needs, e.g. REALM+NONCE or
+// ALTERNATE-SERVER), then SOFTWARE/FINGERPRINT per the usual options.
+func (s *Server) sendError(client Endpoint, transaction_id []byte, code int, reason string, extra []byte) {
+	header, err := makeHeader(ERROR_RESPONSE, 0, MAGIC_COOKIE, transaction_id)
+	if err != nil {
+		return
+	}
+
+	body := makeErrorCodeAttribute(code, reason)
+	body = append(body, extra...)
+
+	if s.opts.Software != "" {
+		body = append(body, makeAttribute(SOFTWARE, []byte(s.opts.Software))...)
+	}
+	if s.opts.Fingerprint {
+		body = appendFingerprint(header, body)
+	}
+
+	header = withLength(header, len(body))
+	s.bind.Send(append(header, body...), client)
+}
+
+// respondSuccess answers with a SUCCESS_RESPONSE carrying XOR-MAPPED-ADDRESS,
+// and MESSAGE-INTEGRITY too if creds is set (a request that authenticated gets
+// an authenticated response back, per RFC 5389 §10.2).
+func (s *Server) respondSuccess(client Endpoint, transaction_id []byte, creds *Credentials) {
+	addr, ok := clientUDPAddr(client)
+	if !ok {
+		return
+	}
+
+	header, err := makeHeader(SUCCESS_RESPONSE, 0, MAGIC_COOKIE, transaction_id)
+	if err != nil {
+		return
+	}
+
+	body := MakeXorAddrAttribute(XOR_MAPPED_ADDRESS, addr, transaction_id)
+
+	if s.opts.Software != "" {
+		body = append(body, makeAttribute(SOFTWARE, []byte(s.opts.Software))...)
+	}
+	if creds != nil {
+		body = appendMessageIntegrity(header, body, creds)
+	}
+	if s.opts.Fingerprint {
+		body = appendFingerprint(header, body)
+	}
+
+	header = withLength(header, len(body))
+	s.bind.Send(append(header, body...), client)
+}
+
+// makeErrorCodeAttribute builds an ERROR-CODE attribute (RFC 5389 §15.6) from a
+// combined class*100+number code and a reason phrase.
+func makeErrorCodeAttribute(code int, reason string) []byte {
+	value := make([]byte, 4, 4+len(reason))
+	value[2] = byte(code / 100)
+	value[3] = byte(code % 100)
+	value = append(value, []byte(reason)...)
+
+	return makeAttribute(ERROR_CODE, value)
+}
+
+// makeAddrAttribute builds a plain (non-XOR) address attribute (RFC 5389
+// §15.1) - unlike XOR-MAPPED-ADDRESS, ALTERNATE-SERVER isn't XOR'd.
+func makeAddrAttribute(attr_type uint16, addr *net.UDPAddr) []byte {
+	family := uint8(0x01)
+	ip := addr.IP.To4()
+	if ip == nil {
+		family = 0x02
+		ip = addr.IP.To16()
+	}
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, uint8(0))
+	binary.Write(buf, binary.BigEndian, family)
+	binary.Write(buf, binary.BigEndian, uint16(addr.Port))
+	buf.Write(ip)
+
+	return makeAttribute(attr_type, buf.Bytes())
+}
+
+func unknownAttributesValue(types []uint16) []byte {
+	buf := new(bytes.Buffer)
+	for _, t := range types {
+		binary.Write(buf, binary.BigEndian, t)
+	}
+	return buf.Bytes()
+}
+
+func newNonce() string {
+	raw := make([]byte, 16)
+	rand.Read(raw)
+	return hex.EncodeToString(raw)
+}
@@ -0,0 +1,181 @@
+package stun_c
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// discardBind is a no-op Bind, for tests that only care about authenticate's
+// return value and not the error responses it sends over the wire.
+type discardBind struct{}
+
+func (discardBind) Send(packet []byte, endpoint Endpoint) error { return nil }
+func (discardBind) Receive(packet []byte) (int, Endpoint, error) {
+	select {} // never called in these tests
+}
+func (discardBind) Close() error { return nil }
+
+func TestServerAuthenticate(t *testing.T) {
+	opts := &ServerOptions{
+		Realm: "example.org",
+		Authenticate: func(username string) (string, bool) {
+			if username == "user" {
+				return "pass", true
+			}
+			return "", false
+		},
+	}
+	s, err := NewServer(discardBind{}, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	client := NewUDPEndpoint(nil, &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1234})
+	transaction_id := []byte("abcdefghijkl")
+	header, err := makeHeader(REQUEST, 0, MAGIC_COOKIE, transaction_id)
+	if err != nil {
+		t.Fatalf("makeHeader() failed: %v", err)
+	}
+
+	t.Run("missing MESSAGE-INTEGRITY gets a 401", func(t *testing.T) {
+		creds, ok := s.authenticate(client, transaction_id, header, nil, "", "", -1, nil)
+		if ok || creds != nil {
+			t.Fatal("expected authenticate to reject a request with no MESSAGE-INTEGRITY")
+		}
+	})
+
+	t.Run("stale nonce gets a 438 and a fresh challenge", func(t *testing.T) {
+		creds, ok := s.authenticate(client, transaction_id, header, []byte{0, 1, 2}, "user", "not-the-real-nonce", 0, []byte{0, 1, 2})
+		if ok || creds != nil {
+			t.Fatal("expected authenticate to reject a stale/unknown nonce")
+		}
+		if s.nonces["1.2.3.4"].value == "" {
+			t.Fatal("expected the 438 challenge to have issued a fresh nonce")
+		}
+	})
+
+	t.Run("a correctly signed retry succeeds", func(t *testing.T) {
+		nonce := s.nonces["1.2.3.4"].value
+
+		body := makeAttribute(USERNAME, []byte("user"))
+		body = append(body, makeAttribute(REALM, []byte("example.org"))...)
+		body = append(body, makeAttribute(NONCE, []byte(nonce))...)
+
+		signingCreds := &Credentials{Username: "user", Realm: "example.org", Password: "pass"}
+		signed := appendMessageIntegrity(header, body, signingCreds)
+		offset := len(body)
+		value := signed[offset+4:]
+
+		creds, ok := s.authenticate(client, transaction_id, header, signed, "user", nonce, offset, value)
+		if !ok || creds == nil {
+			t.Fatal("expected a correctly signed retry to authenticate")
+		}
+		if creds.Username != "user" || creds.Realm != "example.org" || creds.Password != "pass" {
+			t.Fatalf("authenticate() returned unexpected Credentials: %+v", creds)
+		}
+	})
+
+	t.Run("wrong password is rejected", func(t *testing.T) {
+		nonce := s.nonces["1.2.3.4"].value
+
+		body := makeAttribute(USERNAME, []byte("user"))
+		body = append(body, makeAttribute(REALM, []byte("example.org"))...)
+		body = append(body, makeAttribute(NONCE, []byte(nonce))...)
+
+		signed := appendMessageIntegrity(header, body, &Credentials{Username: "user", Realm: "example.org", Password: "wrong"})
+		offset := len(body)
+		value := signed[offset+4:]
+
+		if creds, ok := s.authenticate(client, transaction_id, header, signed, "user", nonce, offset, value); ok || creds != nil {
+			t.Fatal("expected authenticate to reject a bad MESSAGE-INTEGRITY")
+		}
+	})
+}
+
+func TestNewServerRejectsRealmWithoutAuthenticate(t *testing.T) {
+	if _, err := NewServer(nil, &ServerOptions{Realm: "example.org"}); err == nil {
+		t.Fatal("expected NewServer to reject Realm without Authenticate")
+	}
+}
+
+func TestNewServerAllowsRealmWithAuthenticate(t *testing.T) {
+	opts := &ServerOptions{Realm: "example.org", Authenticate: func(string) (string, bool) { return "", false }}
+
+	s, err := NewServer(nil, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s == nil {
+		t.Fatal("expected a non-nil Server")
+	}
+}
+
+func TestAllowTokenBucket(t *testing.T) {
+	s, err := NewServer(nil, &ServerOptions{RateLimit: 1, RateBurst: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !s.allow("1.2.3.4") || !s.allow("1.2.3.4") {
+		t.Fatal("expected the burst to allow the first two requests")
+	}
+	if s.allow("1.2.3.4") {
+		t.Fatal("expected the third request within the same instant to be rate limited")
+	}
+}
+
+func TestAllowDisabledByDefault(t *testing.T) {
+	s, err := NewServer(nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		if !s.allow("1.2.3.4") {
+			t.Fatal("expected allow() to never rate limit when RateLimit <= 0")
+		}
+	}
+}
+
+func TestSweepLockedEvictsStaleEntries(t *testing.T) {
+	s, err := NewServer(nil, &ServerOptions{RateLimit: 1, RateBurst: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s.allow("1.2.3.4")
+	s.nonces["1.2.3.4"] = nonceEntry{value: "stale", issued: time.Now().Add(-2 * entryTTL)}
+	s.buckets["1.2.3.4"].last = time.Now().Add(-2 * entryTTL)
+	s.lastSweep = time.Now().Add(-2 * sweepInterval)
+
+	s.mu.Lock()
+	s.sweepLocked(time.Now())
+	s.mu.Unlock()
+
+	if _, ok := s.buckets["1.2.3.4"]; ok {
+		t.Fatal("expected sweepLocked to evict a bucket untouched for longer than entryTTL")
+	}
+	if _, ok := s.nonces["1.2.3.4"]; ok {
+		t.Fatal("expected sweepLocked to evict a nonce untouched for longer than entryTTL")
+	}
+}
+
+func TestSweepLockedRespectsInterval(t *testing.T) {
+	s, err := NewServer(nil, &ServerOptions{RateLimit: 1, RateBurst: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s.allow("1.2.3.4")
+	s.buckets["1.2.3.4"].last = time.Now().Add(-2 * entryTTL)
+	s.lastSweep = time.Now() // looks like a sweep only just ran
+
+	s.mu.Lock()
+	s.sweepLocked(time.Now())
+	s.mu.Unlock()
+
+	if _, ok := s.buckets["1.2.3.4"]; !ok {
+		t.Fatal("expected sweepLocked to skip sweeping before sweepInterval has elapsed")
+	}
+}
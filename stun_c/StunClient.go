@@ -23,14 +23,33 @@ const ERROR_RESPONSE int16 = 0x0110
 const trans_id_size int8 = 96
 
 // RFC 5389 specified comprehension-required range (0x0000 ~ 0x7FFF)
-const MAPPED_ADDRESS int16 = 0x0001
-const USERNAME int16 = 0x0006
-const MESSAGE_INTEGRITY int16 = 0x0008
-const ERROR_CODE int16 = 0x0009
-const UNKNOWN_ATTRIBUTES int16 = 0x000A
-const REALM int16 = 0x0014
-const NONCE int16 = 0x0015
-const XOR_MAPPED_ADDRESS int16 = 0x0020
+// Attribute types are unsigned (the comprehension-optional range below doesn't
+// fit in a signed int16), so these are uint16 rather than int16 like the message
+// type/length fields above
+const MAPPED_ADDRESS uint16 = 0x0001
+const USERNAME uint16 = 0x0006
+const MESSAGE_INTEGRITY uint16 = 0x0008
+const ERROR_CODE uint16 = 0x0009
+const UNKNOWN_ATTRIBUTES uint16 = 0x000A
+const REALM uint16 = 0x0014
+const NONCE uint16 = 0x0015
+const XOR_MAPPED_ADDRESS uint16 = 0x0020
+
+// RFC 5389 specified comprehension-optional range (0x8000 ~ 0xFFFF)
+const SOFTWARE uint16 = 0x8022
+const FINGERPRINT uint16 = 0x8028
+
+// RFC 5780 attributes for NAT behavior discovery
+const CHANGE_REQUEST uint16 = 0x0003
+const RESPONSE_ORIGIN uint16 = 0x802b
+const OTHER_ADDRESS uint16 = 0x802c
+
+// RFC 5389 §11: ALTERNATE-SERVER redirects the client to a different server,
+// used by Server for load-shedding
+const ALTERNATE_SERVER uint16 = 0x8023
+
+// RFC 5389 §15.5: FINGERPRINT is the CRC-32 of the message XORed with this constant
+const FINGERPRINT_XOR int32 = 0x5354554E
 
 // Error Structures
 type Gen_Random_Error struct {}
@@ -63,6 +82,27 @@ func (e *Unknown_Transaction_Id) Error() string {
 	return fmt.Sprintf("STUN Client: Unknown transaction ID. Our ID: %x, Their ID: %x", e.our_id, e.their_id)
 }
 
+// Response is the parsed result of a STUN response. Success is false for an
+// ERROR_RESPONSE, in which case ErrorCode/ErrorReason/Realm/Nonce are populated so
+// the caller can drive the 401/438 long-term credential retry loop with a fresh
+// nonce. FingerprintOK and MessageIntegrityOK are true only if the response
+// carried that attribute and it verified (MESSAGE-INTEGRITY is only checked when
+// credentials were supplied); false does not tell a missing attribute apart from
+// a failed check.
+type Response struct {
+	Address *net.UDPAddr
+	Success bool
+	ErrorCode int
+	ErrorReason string
+	Realm string
+	Nonce string
+	UnknownAttributes []uint16
+	FingerprintOK bool
+	MessageIntegrityOK bool
+	ResponseOrigin *net.UDPAddr
+	OtherAddress *net.UDPAddr
+}
+
 
 // Helper functions
 func makeHeader(message_type int16, message_length int16, magic_cookie int32, transaction_id []byte) ([]byte, error) {
@@ -86,14 +126,14 @@ func makeHeader(message_type int16, message_length int16, magic_cookie int32, tr
 	return buf.Bytes(), nil
 }
 
-func getSingleAttribute(body []byte) (int16, int16, []byte, int, error) {
+func getSingleAttribute(body []byte) (uint16, uint16, []byte, int, error) {
 	if (len(body) == 0) {
 		return 0, 0, nil, 0, errors.New("Cannot parse empty body.")
 	}
 
 	// Peek at the first and second 16 bits of the STUN attributes
 	type_length := struct {
-		Attr_type, Attr_len int16
+		Attr_type, Attr_len uint16
 	} {0, 0}
 
 	err := binary.Read(bytes.NewBuffer(body), binary.BigEndian, &type_length)
@@ -105,10 +145,14 @@ func getSingleAttribute(body []byte) (int16, int16, []byte, int, error) {
 	value := make([]byte, type_length.Attr_len)
 	copy(value, body[4 : 4 + type_length.Attr_len])
 
-	return type_length.Attr_type, type_length.Attr_len, value, 4 + int(type_length.Attr_len), nil
+	// Attributes are padded to a 4-byte boundary (RFC 5389 15), so the next
+	// attribute may start a few bytes after the logical value ends
+	pad := (4 - int(type_length.Attr_len) % 4) % 4
+
+	return type_length.Attr_type, type_length.Attr_len, value, 4 + int(type_length.Attr_len) + pad, nil
 }
 
-func sendMessage(conn *net.UDPConn, server *net.UDPAddr, header []byte, body []byte) error {
+func sendMessage(bind Bind, server Endpoint, header []byte, body []byte) error {
 	if (len(header) == 0) {
 		return &Message_Send_Error{}
 	}
@@ -125,18 +169,7 @@ func sendMessage(conn *net.UDPConn, server *net.UDPAddr, header []byte, body []b
 		return err
 	}
 
-	msg := message.Bytes()
-	size := message.Len()
-
-	for size != 0 { //send entire message fully
-		n, err := conn.WriteToUDP(msg, server)
-		if (err != nil) {
-			 return err
-		}
-		msg = msg[n : cap(msg)] //resizes the slice
-		size -= n
-	}
-	return nil
+	return bind.Send(message.Bytes(), server)
 }
 
 //Will XOR the first byte slice
@@ -219,19 +252,25 @@ func get_addr(attribute []byte) (*net.UDPAddr, error) { //WARNING: Untested
 	return hostUdpAddr, nil
 }
 
-func recvMessage(conn *net.UDPConn, transaction_id []byte) (*net.UDPAddr, error) {
+func recvMessage(bind Bind, transaction_id []byte, creds *Credentials) (*Response, error) {
 	packet := make([]byte, 1280) // RFC 5389: Allocate enough for IPv6 packets too
 
-	for n := 0; n == 0; {
-		n, _, err := conn.ReadFromUDP(packet)
-		if (err != nil) {
-			return nil, err
-		}
+	n, _, err := bind.Receive(packet)
+	if err != nil {
+		return nil, err
+	}
 
-		if n > 0 {
-			break
-		}
-	} //all data acquired 
+	return parseMessage(packet[:n], transaction_id, creds)
+}
+
+// parseMessage decodes a raw STUN message already read off the wire into a
+// Response, matching it against transaction_id. Split out of recvMessage so
+// Client (Client.go) can parse messages the read loop hands it, rather than
+// each reading its own packet directly off the socket.
+func parseMessage(packet []byte, transaction_id []byte, creds *Credentials) (*Response, error) {
+	if len(packet) < 20 {
+		return nil, errors.New("STUN Client: message shorter than a STUN header")
+	}
 
 	// Define the two different sections of the packet
 	header := packet[0:20]
@@ -249,11 +288,18 @@ func recvMessage(conn *net.UDPConn, transaction_id []byte) (*net.UDPAddr, error)
 		return nil, err
 	}
 
+	// A peer claiming a length past what it actually sent would otherwise slice
+	// out of range below
+	if data.Message_length < 0 || int(data.Message_length) > len(body) {
+		return nil, errors.New("STUN Client: message length exceeds received packet")
+	}
+
 	// Restrict body's length
 	body = body[0:data.Message_length]
 
-	// Use logic to determine IP address & Port
-	if data.Message_type != SUCCESS_RESPONSE {
+	// Success and error responses both carry attributes we care about; anything
+	// else (e.g. an indication) isn't a response to our request at all
+	if data.Message_type != SUCCESS_RESPONSE && data.Message_type != ERROR_RESPONSE {
 		return nil, &Not_Success_Response{header, body}
 	}
 
@@ -261,13 +307,18 @@ func recvMessage(conn *net.UDPConn, transaction_id []byte) (*net.UDPAddr, error)
 		return nil, &Unknown_Transaction_Id{data.Transaction_id[0:12], transaction_id}
 	}
 
+	response := &Response{Success: data.Message_type == SUCCESS_RESPONSE}
+
 	// Slowly read all the attributes
+	remaining := body
 	bytes_read := 0
 	for bytes_read < int(data.Message_length) {
-		attr_type, attr_size, attr_value, r, err := getSingleAttribute(body)
+		offset := bytes_read // bytes of body preceding this attribute, needed to verify MESSAGE-INTEGRITY/FINGERPRINT
+
+		attr_type, _, attr_value, r, err := getSingleAttribute(remaining)
 
 		bytes_read += r
-		body = body[attr_size:]
+		remaining = remaining[r:]
 
 		if err != nil {
 			return nil, err
@@ -276,37 +327,75 @@ func recvMessage(conn *net.UDPConn, transaction_id []byte) (*net.UDPAddr, error)
 		// Parse comprehension required attributes
 		switch (attr_type) {
 			case MAPPED_ADDRESS:
-				return get_addr(attr_value)
+				response.Address, err = get_addr(attr_value)
+				if err != nil {
+					return nil, err
+				}
 
 			case XOR_MAPPED_ADDRESS:
-				return get_addr_XOR(attr_value, transaction_id)
+				response.Address, err = get_addr_XOR(attr_value, transaction_id)
+				if err != nil {
+					return nil, err
+				}
 
-			/* Not implemented, too lazy */
 			case USERNAME:
 				break
 
 			case MESSAGE_INTEGRITY:
-				break
+				if creds != nil {
+					response.MessageIntegrityOK = verifyMessageIntegrity(header, body, offset, creds, attr_value)
+				}
 
 			case ERROR_CODE:
-				break
+				response.ErrorCode, response.ErrorReason = get_error_code(attr_value)
 
 			case REALM:
-				break
+				response.Realm = string(attr_value)
 
 			case NONCE:
+				response.Nonce = string(attr_value)
+
+			case UNKNOWN_ATTRIBUTES:
+				response.UnknownAttributes = get_unknown_attributes(attr_value)
+
+			case RESPONSE_ORIGIN:
+				response.ResponseOrigin, err = get_addr(attr_value)
+				if err != nil {
+					return nil, err
+				}
+
+			case OTHER_ADDRESS:
+				response.OtherAddress, err = get_addr(attr_value)
+				if err != nil {
+					return nil, err
+				}
+
+			/* Not implemented, too lazy */
+			case SOFTWARE:
 				break
+
+			case FINGERPRINT:
+				response.FingerprintOK = verifyFingerprint(header, body, offset, attr_value)
 		}
 
 	}
 
-	return nil, nil
+	return response, nil
+}
+
+// RequestOptions controls the optional attributes attached to an outgoing Binding
+// Request. Attributes are appended in RFC 5389 order: SOFTWARE, then
+// MESSAGE-INTEGRITY (if Credentials is set), then FINGERPRINT last.
+type RequestOptions struct {
+	Software string
+	Credentials *Credentials
+	Fingerprint bool
 }
 
 // Exported functions
-func RequestRemoteIPAndPort(conn *net.UDPConn, server *net.UDPAddr) (*net.UDPAddr, error) {
-	// Ensure that conn and server are not nil
-	if conn == nil || server == nil {
+func RequestRemoteIPAndPort(bind Bind, server Endpoint, opts *RequestOptions) (*Response, error) {
+	// Ensure that bind and server are not nil
+	if bind == nil || server == nil {
 		return nil, &Gen_Random_Error{}
 	}
 
@@ -325,17 +414,38 @@ func RequestRemoteIPAndPort(conn *net.UDPConn, server *net.UDPAddr) (*net.UDPAdd
 		return nil, err
 	}
 
+	var creds *Credentials
+	body := []byte{}
+
+	if opts != nil {
+		if opts.Software != "" {
+			body = append(body, makeAttribute(SOFTWARE, []byte(opts.Software))...)
+		}
+
+		if opts.Credentials != nil {
+			creds = opts.Credentials
+			body = appendMessageIntegrity(request_header, body, creds)
+		}
+
+		if opts.Fingerprint {
+			body = appendFingerprint(request_header, body)
+		}
+	}
+
+	// Now that the body is final, patch the header's message-length field to match
+	request_header = withLength(request_header, len(body))
+
 	// Send the request
-	err = sendMessage(conn, server, request_header, nil)
+	err = sendMessage(bind, server, request_header, body)
 	if (err != nil) {
 		return nil, err
 	}
 
 	// Get the response
-	addr, err := recvMessage(conn, secureRandomNumber)
+	response, err := recvMessage(bind, secureRandomNumber, creds)
 	if (err != nil) {
 		return nil, err
 	}
 
-	return addr, nil
+	return response, nil
 }
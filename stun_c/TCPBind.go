@@ -0,0 +1,65 @@
+package stun_c
+
+import (
+	"net"
+	"sync"
+)
+
+// StreamEndpoint is the Endpoint implementation shared by TCPBind and TLSBind -
+// both are connection-oriented, so the remote address is fixed for the
+// connection's lifetime rather than supplied per-message like UDPEndpoint's.
+type StreamEndpoint struct {
+	local, remote net.Addr
+}
+
+func (e *StreamEndpoint) LocalAddr() net.Addr {
+	return e.local
+}
+
+func (e *StreamEndpoint) RemoteAddr() net.Addr {
+	return e.remote
+}
+
+// TCPBind is the Bind implementation for STUN over TCP (RFC 5389 §7.2.2).
+type TCPBind struct {
+	conn *net.TCPConn
+
+	sendMu sync.Mutex
+}
+
+func NewTCPBind(conn *net.TCPConn) *TCPBind {
+	return &TCPBind{conn: conn}
+}
+
+// Send serializes writes so concurrent callers (e.g. several Client.Do calls
+// sharing this Bind) can't interleave their partial writes mid-message and
+// corrupt the length-prefixed framing on the wire - unlike a UDP datagram, a
+// TCPConn.Write isn't atomic.
+func (b *TCPBind) Send(packet []byte, endpoint Endpoint) error {
+	b.sendMu.Lock()
+	defer b.sendMu.Unlock()
+
+	size := len(packet)
+	for size != 0 { //send entire message fully
+		n, err := b.conn.Write(packet)
+		if (err != nil) {
+			return err
+		}
+		packet = packet[n:]
+		size -= n
+	}
+	return nil
+}
+
+func (b *TCPBind) Receive(packet []byte) (int, Endpoint, error) {
+	n, err := readFramedMessage(b.conn, packet)
+	if (err != nil) {
+		return 0, nil, err
+	}
+
+	return n, &StreamEndpoint{local: b.conn.LocalAddr(), remote: b.conn.RemoteAddr()}, nil
+}
+
+func (b *TCPBind) Close() error {
+	return b.conn.Close()
+}
@@ -0,0 +1,52 @@
+package stun_c
+
+import (
+	"crypto/tls"
+	"sync"
+)
+
+// TLSBind is the Bind implementation for STUN over TLS (RFC 5389 §7.2.2), using
+// the same length-prefixed framing as TCPBind since tls.Conn is just a stream
+// transport underneath.
+type TLSBind struct {
+	conn *tls.Conn
+
+	sendMu sync.Mutex
+}
+
+func NewTLSBind(conn *tls.Conn) *TLSBind {
+	return &TLSBind{conn: conn}
+}
+
+// Send serializes writes so concurrent callers (e.g. several Client.Do calls
+// sharing this Bind) can't interleave their partial writes mid-message and
+// corrupt the length-prefixed framing on the wire - unlike a UDP datagram, a
+// tls.Conn.Write isn't atomic.
+func (b *TLSBind) Send(packet []byte, endpoint Endpoint) error {
+	b.sendMu.Lock()
+	defer b.sendMu.Unlock()
+
+	size := len(packet)
+	for size != 0 { //send entire message fully
+		n, err := b.conn.Write(packet)
+		if (err != nil) {
+			return err
+		}
+		packet = packet[n:]
+		size -= n
+	}
+	return nil
+}
+
+func (b *TLSBind) Receive(packet []byte) (int, Endpoint, error) {
+	n, err := readFramedMessage(b.conn, packet)
+	if (err != nil) {
+		return 0, nil, err
+	}
+
+	return n, &StreamEndpoint{local: b.conn.LocalAddr(), remote: b.conn.RemoteAddr()}, nil
+}
+
+func (b *TLSBind) Close() error {
+	return b.conn.Close()
+}
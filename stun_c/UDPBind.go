@@ -0,0 +1,63 @@
+package stun_c
+
+import (
+	"errors"
+	"net"
+)
+
+// UDPEndpoint is the Endpoint implementation for UDPBind.
+type UDPEndpoint struct {
+	local, remote *net.UDPAddr
+}
+
+func NewUDPEndpoint(local, remote *net.UDPAddr) *UDPEndpoint {
+	return &UDPEndpoint{local: local, remote: remote}
+}
+
+func (e *UDPEndpoint) LocalAddr() net.Addr {
+	return e.local
+}
+
+func (e *UDPEndpoint) RemoteAddr() net.Addr {
+	return e.remote
+}
+
+// UDPBind is the Bind implementation for plain UDP.
+type UDPBind struct {
+	conn *net.UDPConn
+}
+
+func NewUDPBind(conn *net.UDPConn) *UDPBind {
+	return &UDPBind{conn: conn}
+}
+
+func (b *UDPBind) Send(packet []byte, endpoint Endpoint) error {
+	addr, ok := endpoint.RemoteAddr().(*net.UDPAddr)
+	if !ok {
+		return errors.New("STUN Client: UDPBind requires a UDP endpoint")
+	}
+
+	size := len(packet)
+	for size != 0 { //send entire message fully
+		n, err := b.conn.WriteToUDP(packet, addr)
+		if (err != nil) {
+			return err
+		}
+		packet = packet[n:]
+		size -= n
+	}
+	return nil
+}
+
+func (b *UDPBind) Receive(packet []byte) (int, Endpoint, error) {
+	n, addr, err := b.conn.ReadFromUDP(packet)
+	if (err != nil) {
+		return 0, nil, err
+	}
+
+	return n, &UDPEndpoint{local: b.conn.LocalAddr().(*net.UDPAddr), remote: addr}, nil
+}
+
+func (b *UDPBind) Close() error {
+	return b.conn.Close()
+}
@@ -0,0 +1,94 @@
+package stun_c
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+)
+
+// The functions below expose stun_c's STUN wire-format primitives - header
+// encoding, attribute TLV encoding/decoding, MESSAGE-INTEGRITY/FINGERPRINT - to
+// other STUN-based protocols built on top of this package, such as turn_c's TURN
+// client. They're thin wrappers around the same unexported helpers
+// RequestRemoteIPAndPort and recvMessage already use.
+
+func MakeHeader(message_type int16, message_length int16, magic_cookie int32, transaction_id []byte) ([]byte, error) {
+	return makeHeader(message_type, message_length, magic_cookie, transaction_id)
+}
+
+func MakeAttribute(attr_type uint16, value []byte) []byte {
+	return makeAttribute(attr_type, value)
+}
+
+func GetAttribute(body []byte) (uint16, uint16, []byte, int, error) {
+	return getSingleAttribute(body)
+}
+
+func WithLength(header []byte, body_len int) []byte {
+	return withLength(header, body_len)
+}
+
+func AppendMessageIntegrity(header, body []byte, creds *Credentials) []byte {
+	return appendMessageIntegrity(header, body, creds)
+}
+
+func AppendFingerprint(header, body []byte) []byte {
+	return appendFingerprint(header, body)
+}
+
+func VerifyMessageIntegrity(header, body []byte, offset int, creds *Credentials, received []byte) bool {
+	return verifyMessageIntegrity(header, body, offset, creds, received)
+}
+
+func VerifyFingerprint(header, body []byte, offset int, received []byte) bool {
+	return verifyFingerprint(header, body, offset, received)
+}
+
+func GetErrorCode(attribute []byte) (int, string) {
+	return get_error_code(attribute)
+}
+
+func GetUnknownAttributes(attribute []byte) []uint16 {
+	return get_unknown_attributes(attribute)
+}
+
+func GetAddr(attribute []byte) (*net.UDPAddr, error) {
+	return get_addr(attribute)
+}
+
+func GetAddrXOR(attribute, transaction_id []byte) (*net.UDPAddr, error) {
+	return get_addr_XOR(attribute, transaction_id)
+}
+
+// MakeXorAddrAttribute builds an XOR-ed address attribute (XOR-MAPPED-ADDRESS,
+// XOR-PEER-ADDRESS, XOR-RELAYED-ADDRESS, ...) from a concrete address - the
+// encoding counterpart to GetAddrXOR, needed by protocols (like TURN) that send
+// XOR-ed addresses rather than only ever receiving them.
+func MakeXorAddrAttribute(attr_type uint16, addr *net.UDPAddr, transaction_id []byte) []byte {
+	family := uint8(0x02)
+	raw_ip := []byte(addr.IP.To16())
+	if ip4 := addr.IP.To4(); ip4 != nil {
+		family = 0x01
+		raw_ip = ip4
+	}
+
+	magic_cookie := make([]byte, 4)
+	binary.BigEndian.PutUint32(magic_cookie, uint32(MAGIC_COOKIE))
+
+	xor_key := magic_cookie
+	if family == 0x02 {
+		xor_key = append(append([]byte{}, magic_cookie...), transaction_id...)
+	}
+
+	xored_ip := make([]byte, len(raw_ip))
+	copy(xored_ip, raw_ip)
+	xor_bytes(xored_ip, xor_key)
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, uint8(0))
+	binary.Write(buf, binary.BigEndian, family)
+	binary.Write(buf, binary.BigEndian, uint16(addr.Port)^uint16(uint32(MAGIC_COOKIE)>>16))
+	buf.Write(xored_ip)
+
+	return makeAttribute(attr_type, buf.Bytes())
+}
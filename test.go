@@ -3,7 +3,8 @@ package main
 import (
 	"fmt"
 	"net"
-	"./stun_c"
+
+	"github.com/jameshi16/StunClientGo/stun_c"
 )
 
 func main() {
@@ -19,12 +20,15 @@ func main() {
 		return
 	}
 
-	remote_addr, err := stun_c.RequestRemoteIPAndPort(local_socket, server)
+	bind := stun_c.NewUDPBind(local_socket)
+	server_endpoint := stun_c.NewUDPEndpoint(nil, server)
+
+	response, err := stun_c.RequestRemoteIPAndPort(bind, server_endpoint, nil)
 	if (err != nil) {
 		fmt.Println(err)
 		return
 	}
 
-	fmt.Printf("Local socket: %s, Remote socket: %s\n", local_socket.LocalAddr(), remote_addr)
+	fmt.Printf("Local socket: %s, Remote socket: %s\n", local_socket.LocalAddr(), response.Address)
 	return
 }
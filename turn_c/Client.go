@@ -0,0 +1,349 @@
+package turn_c
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jameshi16/StunClientGo/stun_c"
+)
+
+// channelFrame is a demultiplexed ChannelData frame (RFC 5766 §11.4), handed
+// from Client's read loop to whichever TURNConn is waiting in ReadFrom.
+type channelFrame struct {
+	channel uint16
+	payload []byte
+}
+
+type pendingTurnRequest struct {
+	ch chan *response
+}
+
+// Client is a TURN client bound to a single allocation (RFC 5766 §5). Create
+// one with Allocate. A single goroutine reads bind on the Client's behalf and
+// demultiplexes every incoming message - STUN responses to per-transaction
+// waiters, Data Indications and ChannelData to TURNConn - so request methods
+// (Refresh, CreatePermission, ChannelBind) can run safely alongside a
+// concurrent TURNConn.ReadFrom loop instead of racing it for bind.Receive.
+// Those request methods retransmit on stun_c.Retransmit's RFC 5389 §7.2.1
+// schedule (RFC 5766 §6.1 requires it), the same one stun_c.Client.Do uses.
+type Client struct {
+	bind stun_c.Bind
+	server stun_c.Endpoint
+	creds *stun_c.Credentials
+	nonce string
+
+	mu sync.Mutex
+	pending map[string]*pendingTurnRequest
+
+	incoming chan *response
+	channelData chan channelFrame
+	done chan struct{}
+	readErr error
+
+	RelayedAddress *net.UDPAddr
+	MappedAddress *net.UDPAddr
+	Lifetime time.Duration
+}
+
+func newClient(bind stun_c.Bind, server stun_c.Endpoint) *Client {
+	c := &Client{
+		bind: bind,
+		server: server,
+		pending: make(map[string]*pendingTurnRequest),
+		incoming: make(chan *response, 32),
+		channelData: make(chan channelFrame, 256),
+		done: make(chan struct{}),
+	}
+	go c.readLoop()
+	return c
+}
+
+func (c *Client) readLoop() {
+	defer close(c.done)
+
+	packet := make([]byte, 1500)
+	for {
+		n, _, err := c.bind.Receive(packet)
+		if err != nil {
+			c.readErr = err
+			return
+		}
+		if n < 4 {
+			continue
+		}
+
+		// RFC 5766 §11.4: ChannelData's leading two bits are always 01, while
+		// STUN messages' leading two bits are always 00 - that's the whole
+		// demultiplexer, no separate framing byte needed.
+		if packet[0]>>6 == 0b01 {
+			channel := binary.BigEndian.Uint16(packet[0:2])
+			length := binary.BigEndian.Uint16(packet[2:4])
+			if int(length) > n-4 {
+				continue // truncated ChannelData, drop it
+			}
+
+			frame := channelFrame{channel, append([]byte{}, packet[4:4+int(length)]...)}
+			select {
+			case c.channelData <- frame:
+			default: // nobody's reading fast enough; drop rather than block the demultiplexer
+			}
+			continue
+		}
+
+		resp, id, err := parseResponse(packet[:n])
+		if err != nil {
+			continue // unparseable, drop it
+		}
+
+		c.mu.Lock()
+		pending, ok := c.pending[string(id)]
+		c.mu.Unlock()
+
+		if ok {
+			select {
+			case pending.ch <- resp:
+			default: // doRequest already gave up and stopped listening
+			}
+			continue
+		}
+
+		if resp.peerAddress != nil && resp.data != nil {
+			select {
+			case c.incoming <- resp:
+			default: // nobody's reading fast enough; drop rather than block the demultiplexer
+			}
+		}
+	}
+}
+
+// doRequest sends a Request and waits for its response, dispatched to it by
+// readLoop. It retransmits on stun_c.Retransmit's RFC 5389 §7.2.1 schedule,
+// same as stun_c.Client.Do - RFC 5766 §6.1 requires Allocate (and by
+// extension Refresh/CreatePermission/ChannelBind) to use it too, rather than
+// giving up after one dropped packet. doRequest can't just call Do itself,
+// though: readLoop is the only thing allowed to call bind.Receive (that's the
+// whole point of demultiplexing ChannelData/Data Indications away from
+// TURNConn.ReadFrom without racing it), so a second reader inside Do would
+// reintroduce that race. buildBody receives the transaction ID so attributes
+// that need it (XOR-PEER-ADDRESS et al.) can be built correctly; creds, if
+// non-nil, appends MESSAGE-INTEGRITY.
+func (c *Client) doRequest(method int, buildBody func(transaction_id []byte) []byte, creds *stun_c.Credentials) (*response, error) {
+	transaction_id, err := newTransactionID()
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := stun_c.MakeHeader(messageType(method, class_request), 0, stun_c.MAGIC_COOKIE, transaction_id)
+	if err != nil {
+		return nil, err
+	}
+
+	body := buildBody(transaction_id)
+	if creds != nil {
+		body = stun_c.AppendMessageIntegrity(header, body, creds)
+	}
+	header = stun_c.WithLength(header, len(body))
+	message := append(header, body...)
+
+	id := string(transaction_id)
+	ch := make(chan *response, 1)
+
+	c.mu.Lock()
+	c.pending[id] = &pendingTurnRequest{ch: ch}
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	// ctx is cancelled early if readLoop dies, so a dead bind doesn't leave
+	// Retransmit waiting out the full 39.5s schedule for a response that can
+	// now never arrive.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-c.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	resp, err := stun_c.Retransmit(ctx, false, func() error {
+		return c.bind.Send(message, c.server)
+	}, ch)
+
+	switch err {
+	case stun_c.ErrGiveUp:
+		return nil, &Allocation_Error{0, "gave up retransmitting, no response"}
+	case context.Canceled:
+		return nil, c.readErr
+	default:
+		return resp, err
+	}
+}
+
+func (c *Client) longTermAttrs() []byte {
+	body := stun_c.MakeAttribute(stun_c.USERNAME, []byte(c.creds.Username))
+	body = append(body, stun_c.MakeAttribute(stun_c.REALM, []byte(c.creds.Realm))...)
+	body = append(body, stun_c.MakeAttribute(stun_c.NONCE, []byte(c.nonce))...)
+	return body
+}
+
+// Allocate performs the TURN Allocate handshake (RFC 5766 §6.2), including the
+// 401 Unauthorized + REALM + NONCE round trip long-term credentials require.
+// The returned Client is bound to the resulting relayed allocation.
+func Allocate(bind stun_c.Bind, server stun_c.Endpoint, username, password string) (*Client, error) {
+	client := newClient(bind, server)
+
+	requested_transport := func(transaction_id []byte) []byte {
+		return stun_c.MakeAttribute(REQUESTED_TRANSPORT, []byte{udp_transport, 0, 0, 0})
+	}
+
+	resp, err := client.doRequest(method_allocate, requested_transport, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.success {
+		return nil, &Allocation_Error{0, "server allocated without requiring authentication, which this client does not support"}
+	}
+	if resp.errorCode != 401 {
+		return nil, &Allocation_Error{resp.errorCode, resp.errorReason}
+	}
+
+	client.creds = &stun_c.Credentials{Username: username, Realm: resp.realm, Password: password}
+	client.nonce = resp.nonce
+
+	resp, err = client.doRequest(method_allocate, func(transaction_id []byte) []byte {
+		body := stun_c.MakeAttribute(REQUESTED_TRANSPORT, []byte{udp_transport, 0, 0, 0})
+		return append(body, client.longTermAttrs()...)
+	}, client.creds)
+	if err != nil {
+		return nil, err
+	}
+
+	if !resp.success {
+		return nil, &Allocation_Error{resp.errorCode, resp.errorReason}
+	}
+	if resp.relayedAddress == nil {
+		return nil, &Allocation_Error{0, "success response did not include XOR-RELAYED-ADDRESS"}
+	}
+
+	client.RelayedAddress = resp.relayedAddress
+	client.MappedAddress = resp.mappedAddress
+	client.Lifetime = time.Duration(resp.lifetime) * time.Second
+
+	return client, nil
+}
+
+// Refresh renews the allocation's lifetime, or tears it down if lifetime is 0
+// (RFC 5766 §7).
+func (c *Client) Refresh(lifetime time.Duration) error {
+	return c.refresh(lifetime, true)
+}
+
+func (c *Client) refresh(lifetime time.Duration, retry_on_stale_nonce bool) error {
+	resp, err := c.doRequest(method_refresh, func(transaction_id []byte) []byte {
+		value := make([]byte, 4)
+		binary.BigEndian.PutUint32(value, uint32(lifetime/time.Second))
+
+		body := stun_c.MakeAttribute(LIFETIME, value)
+		return append(body, c.longTermAttrs()...)
+	}, c.creds)
+	if err != nil {
+		return err
+	}
+
+	if !resp.success {
+		if resp.errorCode == 438 && retry_on_stale_nonce { // Stale Nonce: retry once with the fresh one
+			c.nonce = resp.nonce
+			return c.refresh(lifetime, false)
+		}
+		return &Allocation_Error{resp.errorCode, resp.errorReason}
+	}
+
+	c.Lifetime = time.Duration(resp.lifetime) * time.Second
+	return nil
+}
+
+// CreatePermission installs a permission for peer, so Send/ChannelData traffic
+// addressed to it is allowed through the relay (RFC 5766 §9).
+func (c *Client) CreatePermission(peer *net.UDPAddr) error {
+	return c.createPermission(peer, true)
+}
+
+func (c *Client) createPermission(peer *net.UDPAddr, retry_on_stale_nonce bool) error {
+	resp, err := c.doRequest(method_create_permission, func(transaction_id []byte) []byte {
+		body := stun_c.MakeXorAddrAttribute(XOR_PEER_ADDRESS, peer, transaction_id)
+		return append(body, c.longTermAttrs()...)
+	}, c.creds)
+	if err != nil {
+		return err
+	}
+
+	if !resp.success {
+		if resp.errorCode == 438 && retry_on_stale_nonce {
+			c.nonce = resp.nonce
+			return c.createPermission(peer, false)
+		}
+		return &Allocation_Error{resp.errorCode, resp.errorReason}
+	}
+
+	return nil
+}
+
+// ChannelBind binds channel (0x4000-0x7FFE) to peer, so traffic to/from it can
+// use the cheaper 4-byte ChannelData framing instead of Send/Data Indications
+// (RFC 5766 §11). ChannelBind implicitly installs a permission for peer too.
+func (c *Client) ChannelBind(channel uint16, peer *net.UDPAddr) error {
+	return c.channelBind(channel, peer, true)
+}
+
+func (c *Client) channelBind(channel uint16, peer *net.UDPAddr, retry_on_stale_nonce bool) error {
+	resp, err := c.doRequest(method_channel_bind, func(transaction_id []byte) []byte {
+		channel_value := make([]byte, 4) // 2 bytes channel number, 2 bytes RFFU (RFC 5766 §14.1)
+		binary.BigEndian.PutUint16(channel_value[0:2], channel)
+
+		body := stun_c.MakeAttribute(CHANNEL_NUMBER, channel_value)
+		body = append(body, stun_c.MakeXorAddrAttribute(XOR_PEER_ADDRESS, peer, transaction_id)...)
+		return append(body, c.longTermAttrs()...)
+	}, c.creds)
+	if err != nil {
+		return err
+	}
+
+	if !resp.success {
+		if resp.errorCode == 438 && retry_on_stale_nonce {
+			c.nonce = resp.nonce
+			return c.channelBind(channel, peer, false)
+		}
+		return &Allocation_Error{resp.errorCode, resp.errorReason}
+	}
+
+	return nil
+}
+
+// send wraps payload in a Send Indication addressed to peer (RFC 5766 §10.1).
+// Indications get no response, so this is fire-and-forget.
+func (c *Client) send(peer *net.UDPAddr, payload []byte) error {
+	transaction_id, err := newTransactionID()
+	if err != nil {
+		return err
+	}
+
+	body := stun_c.MakeXorAddrAttribute(XOR_PEER_ADDRESS, peer, transaction_id)
+	body = append(body, stun_c.MakeAttribute(DATA, payload)...)
+
+	header, err := stun_c.MakeHeader(messageType(method_send, class_indication), 0, stun_c.MAGIC_COOKIE, transaction_id)
+	if err != nil {
+		return err
+	}
+	header = stun_c.WithLength(header, len(body))
+
+	return c.bind.Send(append(header, body...), c.server)
+}
@@ -0,0 +1,131 @@
+package turn_c
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// TURNConn adapts an allocated Client to the net.PacketConn interface, so
+// existing code written against a plain UDP-like connection can transparently
+// run over a TURN relay instead. ReadFrom/WriteTo wrap outgoing payloads in Send
+// Indications or ChannelData messages and unwrap incoming ones.
+type TURNConn struct {
+	client *Client
+
+	mu sync.Mutex
+	channels map[string]uint16 // peer address string -> bound channel number
+	next_channel uint16
+}
+
+// NewTURNConn wraps an already-allocated Client as a net.PacketConn.
+func NewTURNConn(client *Client) *TURNConn {
+	return &TURNConn{client: client, channels: make(map[string]uint16), next_channel: 0x4000}
+}
+
+// Bind installs a channel binding for peer (RFC 5766 §11), so WriteTo/ReadFrom
+// use the cheaper 4-byte ChannelData framing for it instead of Send/Data
+// Indications. Not required before WriteTo - it falls back to a Send Indication
+// for any peer that isn't channel-bound.
+func (c *TURNConn) Bind(peer *net.UDPAddr) error {
+	c.mu.Lock()
+	channel := c.next_channel
+	c.next_channel++
+	c.mu.Unlock()
+
+	if err := c.client.ChannelBind(channel, peer); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.channels[peer.String()] = channel
+	c.mu.Unlock()
+
+	return nil
+}
+
+func (c *TURNConn) channelFor(peer *net.UDPAddr) (uint16, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	channel, ok := c.channels[peer.String()]
+	return channel, ok
+}
+
+func (c *TURNConn) peerForChannel(channel uint16) (*net.UDPAddr, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for addr, bound := range c.channels {
+		if bound == channel {
+			udp_addr, err := net.ResolveUDPAddr("udp", addr)
+			if err != nil {
+				return nil, false
+			}
+			return udp_addr, true
+		}
+	}
+	return nil, false
+}
+
+func (c *TURNConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	peer, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, errors.New("turn_c: TURNConn only relays to UDP peers")
+	}
+
+	if channel, bound := c.channelFor(peer); bound {
+		header := make([]byte, 4) // RFC 5766 §11.4: 2 bytes channel number, 2 bytes length
+		binary.BigEndian.PutUint16(header[0:2], channel)
+		binary.BigEndian.PutUint16(header[2:4], uint16(len(p)))
+
+		if err := c.client.bind.Send(append(header, p...), c.client.server); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	if err := c.client.send(peer, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ReadFrom reads whatever Client.readLoop's demultiplexer hands it next -
+// ChannelData frames and Data Indications both arrive here, already separated
+// from STUN responses to Client's own requests (Refresh, CreatePermission, ...)
+// so the two can run concurrently on the same underlying Bind without racing.
+func (c *TURNConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		select {
+		case frame := <-c.client.channelData:
+			peer, bound := c.peerForChannel(frame.channel)
+			if !bound {
+				continue // data on a channel we never bound, drop it
+			}
+			return copy(p, frame.payload), peer, nil
+
+		case resp := <-c.client.incoming:
+			return copy(p, resp.data), resp.peerAddress, nil
+
+		case <-c.client.done:
+			return 0, nil, c.client.readErr
+		}
+	}
+}
+
+func (c *TURNConn) Close() error {
+	return c.client.bind.Close()
+}
+
+func (c *TURNConn) LocalAddr() net.Addr {
+	return c.client.RelayedAddress
+}
+
+// Deadlines aren't implemented - each setter returns os.ErrNoDeadline, and
+// ReadFrom/WriteTo never time out.
+func (c *TURNConn) SetDeadline(t time.Time) error { return os.ErrNoDeadline }
+func (c *TURNConn) SetReadDeadline(t time.Time) error { return os.ErrNoDeadline }
+func (c *TURNConn) SetWriteDeadline(t time.Time) error { return os.ErrNoDeadline }
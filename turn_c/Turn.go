@@ -0,0 +1,157 @@
+// Package turn_c implements a TURN (RFC 5766/8656) client on top of stun_c,
+// reusing its STUN header/attribute wire-format machinery (stun_c.Wire.go)
+// rather than re-implementing it.
+package turn_c
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/jameshi16/StunClientGo/stun_c"
+)
+
+// TURN method values (RFC 5766 §13)
+const method_allocate = 0x003
+const method_refresh = 0x004
+const method_send = 0x006
+const method_data = 0x007
+const method_create_permission = 0x008
+const method_channel_bind = 0x009
+
+// STUN message classes (RFC 5389 §6), used below to build the combined
+// method+class message type
+const class_request = 0x0
+const class_indication = 0x1
+const class_success = 0x2
+const class_error = 0x3
+
+// messageType interleaves a TURN method and a STUN class into the 16-bit STUN
+// message type field. Every TURN method used here fits in the low 4 bits, so
+// there's no need for the general bit-scattering RFC 5389 §6 describes for
+// larger method values.
+func messageType(method, class int) int16 {
+	c0 := class & 0x1
+	c1 := (class >> 1) & 0x1
+	return int16(method | (c0 << 4) | (c1 << 8))
+}
+
+func messageClass(message_type int16) int {
+	return int((message_type>>4)&0x1) | int((message_type>>7)&0x2)
+}
+
+// TURN attributes (RFC 5766 §14)
+const CHANNEL_NUMBER uint16 = 0x000C
+const LIFETIME uint16 = 0x000D
+const XOR_PEER_ADDRESS uint16 = 0x0012
+const DATA uint16 = 0x0013
+const XOR_RELAYED_ADDRESS uint16 = 0x0016
+const REQUESTED_TRANSPORT uint16 = 0x0019
+
+const udp_transport byte = 17 // IANA protocol number for UDP, RFC 5766 §14.7
+
+// Error structures (matching stun_c's convention of a struct type per error)
+type Allocation_Error struct {
+	code int
+	reason string
+}
+
+func (e *Allocation_Error) Error() string {
+	return fmt.Sprintf("turn_c: request failed: %d %s", e.code, e.reason)
+}
+
+// response is the parsed result of any TURN/STUN message this package sends -
+// which fields are populated depends on which request or indication it's a
+// response to.
+type response struct {
+	success bool
+	errorCode int
+	errorReason string
+	realm string
+	nonce string
+	mappedAddress *net.UDPAddr
+	relayedAddress *net.UDPAddr
+	lifetime uint32
+	peerAddress *net.UDPAddr
+	data []byte
+}
+
+func newTransactionID() ([]byte, error) {
+	id := make([]byte, 12)
+	_, err := rand.Read(id)
+	return id, err
+}
+
+// parseResponse decodes a raw STUN/TURN message and extracts its own
+// transaction ID (needed to undo XOR-PEER-ADDRESS/XOR-RELAYED-ADDRESS encoding)
+// - Client.readLoop is the one that matches it against an outstanding request.
+func parseResponse(packet []byte) (*response, []byte, error) {
+	if len(packet) < 20 {
+		return nil, nil, &Allocation_Error{0, "message shorter than a STUN header"}
+	}
+
+	header := packet[0:20]
+	message_type := int16(binary.BigEndian.Uint16(header[0:2]))
+	message_length := binary.BigEndian.Uint16(header[2:4])
+	transaction_id := append([]byte{}, header[8:20]...)
+
+	if len(packet) < 20+int(message_length) {
+		return nil, nil, &Allocation_Error{0, "message shorter than its own length field claims"}
+	}
+	body := packet[20 : 20+int(message_length)]
+
+	resp := &response{success: messageClass(message_type) == class_success}
+
+	remaining := body
+	bytes_read := 0
+
+	for bytes_read < len(body) {
+		attr_type, _, attr_value, r, err := stun_c.GetAttribute(remaining)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		bytes_read += r
+		remaining = remaining[r:]
+
+		switch attr_type {
+		case stun_c.ERROR_CODE:
+			resp.errorCode, resp.errorReason = stun_c.GetErrorCode(attr_value)
+
+		case stun_c.REALM:
+			resp.realm = string(attr_value)
+
+		case stun_c.NONCE:
+			resp.nonce = string(attr_value)
+
+		case stun_c.XOR_MAPPED_ADDRESS:
+			resp.mappedAddress, err = stun_c.GetAddrXOR(attr_value, transaction_id)
+			if err != nil {
+				return nil, nil, err
+			}
+
+		case XOR_RELAYED_ADDRESS:
+			resp.relayedAddress, err = stun_c.GetAddrXOR(attr_value, transaction_id)
+			if err != nil {
+				return nil, nil, err
+			}
+
+		case LIFETIME:
+			if len(attr_value) >= 4 {
+				resp.lifetime = binary.BigEndian.Uint32(attr_value)
+			}
+
+		case XOR_PEER_ADDRESS:
+			resp.peerAddress, err = stun_c.GetAddrXOR(attr_value, transaction_id)
+			if err != nil {
+				return nil, nil, err
+			}
+
+		case DATA:
+			resp.data = append([]byte{}, attr_value...)
+		}
+	}
+
+	return resp, transaction_id, nil
+}